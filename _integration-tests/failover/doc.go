@@ -0,0 +1,33 @@
+// +build integration
+
+//
+// ubuntu-emu - Tool to download and run Ubuntu Touch emulator instances
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+// Package failover drives a real `ubuntu-emu create` instance under qemu and
+// asserts that the A/B try-boot rollback implemented in diskimage.BootState
+// actually recovers from a corrupted active slot, borrowing the failover
+// pattern (crash the active rootfs, reboot, check the other slot came up)
+// from the snappy integration tests.
+//
+// These tests boot a full emulator image and are slow and host-dependent
+// (qemu, ssh, binfmt for armhf), so they're excluded from `go test ./...`
+// by the integration build tag and are meant to be run explicitly:
+//
+//	go test -tags integration ./_integration-tests/failover/...
+package failover
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
@@ -0,0 +1,246 @@
+// +build integration
+
+//
+// ubuntu-emu - Tool to download and run Ubuntu Touch emulator instances
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package failover
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ubports/goget-ubuntu-touch/blueprint"
+	"github.com/ubports/goget-ubuntu-touch/diskimage"
+
+	"gopkg.in/yaml.v2"
+)
+
+// guestStatePath is the boot-state env file's path inside the running
+// guest for each bootloader, mirroring bootDir/espDir's layout on the
+// host image (see diskimage/bootloader_uboot.go and bootloader_grub.go).
+var guestStatePath = map[string]string{
+	"uboot": "/boot/uboot/snappy-system.txt",
+	"grub":  "/boot/efi/EFI/ubuntu/grubenv",
+}
+
+// guestKernelPath is where the trial slot's kernel lives inside the guest,
+// mirroring the snappy_ab subdirectory the u-boot/grub boot scripts load
+// the kernel from (see snappySystemTemplate and grubCfgTemplate).
+var guestKernelPath = map[string]string{
+	"uboot": "/boot/uboot/b/vmlinuz",
+	"grub":  "/boot/efi/b/vmlinuz",
+}
+
+// canaryPath is written under the writable partition, which both slots
+// share, so the "a" written before the trial boot must still read back
+// once the rollback has landed on slot a again.
+const canaryPath = "/writable/failover-canary"
+
+// TestFailover boots an emulator instance for each supported bootloader,
+// marks a try-boot to the other slot, corrupts that slot's kernel with a
+// zero-size boot file so it can't possibly come up, then power-cycles the
+// guest twice and lets the bootloader's own read-check-flip logic (the
+// snappy_boot/grub.cfg stamp dance SetupBoot wires up) perform the actual
+// rollback: the first cycle boots the corrupted trial slot and never
+// reaches ssh, the second finds the stamp that attempt left behind and
+// flips back to the known-good slot before the kernel even loads. The test
+// only observes the outcome afterwards, via ssh and a read-only BootState
+// parse of the guest's env file - it never drives the rollback itself.
+func TestFailover(t *testing.T) {
+	for _, bootloader := range []string{"uboot", "grub"} {
+		bootloader := bootloader
+		t.Run(bootloader, func(t *testing.T) {
+			testFailover(t, bootloader)
+		})
+	}
+}
+
+func testFailover(t *testing.T, bootloader string) {
+	instanceName := "failover-" + bootloader
+	sshPort := 2222
+
+	bp := blueprint.I386
+	bp.Bootloader = bootloader
+
+	bpFile, err := ioutil.TempFile("", "failover-blueprint")
+	if err != nil {
+		t.Fatalf("creating blueprint file: %s", err)
+	}
+	defer os.Remove(bpFile.Name())
+
+	bpData, err := yaml.Marshal(bp)
+	if err != nil {
+		t.Fatalf("marshalling blueprint: %s", err)
+	}
+	if _, err := bpFile.Write(bpData); err != nil {
+		t.Fatalf("writing blueprint: %s", err)
+	}
+	bpFile.Close()
+
+	// --bootloader only takes effect with --blueprint (the classic flow
+	// always produces a uboot image), so drive the bootloader choice
+	// through a generated blueprint instead.
+	if out, err := exec.Command("ubuntu-emu", "create", instanceName,
+		"--blueprint="+bpFile.Name()).CombinedOutput(); err != nil {
+		t.Fatalf("ubuntu-emu create: %s: %s", err, out)
+	}
+
+	dataDir := os.ExpandEnv(filepath.Join("$HOME", ".cache", "ubuntu-emulator", instanceName))
+
+	inst, err := bootInstance(dataDir, sshPort)
+	if err != nil {
+		t.Fatalf("bootInstance: %s", err)
+	}
+	defer inst.shutdown()
+
+	if _, err := inst.ssh(fmt.Sprintf("sudo sh -c 'echo a > %s'", canaryPath)); err != nil {
+		t.Fatalf("writing canary: %s", err)
+	}
+
+	if err := markTryBoot(inst, bootloader, "b"); err != nil {
+		t.Fatalf("markTryBoot: %s", err)
+	}
+
+	kernelPath, ok := guestKernelPath[bootloader]
+	if !ok {
+		t.Fatalf("no known kernel path for bootloader %q", bootloader)
+	}
+	// zero-size kernel: corrupt the trial slot's own kernel so the
+	// bootloader has something real to fail to boot and recover from.
+	if _, err := inst.ssh(fmt.Sprintf("sudo truncate -s 0 %s", kernelPath)); err != nil {
+		t.Fatalf("corrupting kernel: %s", err)
+	}
+
+	// First power cycle: the bootloader boots into the corrupted trial
+	// slot per the try-boot marker staged above. It leaves its own stamp
+	// behind and then fails to reach a usable kernel, so ssh never comes
+	// back - that failure is expected, not a test error.
+	if err := inst.powerCycle(2 * time.Minute); err == nil {
+		t.Fatal("expected the corrupted trial slot to fail to boot, but ssh came back up")
+	}
+
+	// Second power cycle: the bootloader sees its own stamp from the
+	// failed attempt and flips back to the known-good slot before this
+	// boot even reaches a kernel.
+	if err := inst.powerCycle(2 * time.Minute); err != nil {
+		t.Fatalf("powerCycle (after failed trial boot): %s", err)
+	}
+
+	slot, err := currentSlot(inst, bootloader)
+	if err != nil {
+		t.Fatalf("currentSlot: %s", err)
+	}
+	if slot != "a" {
+		t.Fatalf("current slot = %q, want %q (rollback should have landed on the known-good slot)", slot, "a")
+	}
+
+	canary, err := inst.ssh(fmt.Sprintf("cat %s", canaryPath))
+	if err != nil {
+		t.Fatalf("reading canary: %s", err)
+	}
+	if canary != "a" {
+		t.Fatalf("canary = %q, want %q", canary, "a")
+	}
+}
+
+// markTryBoot pulls the guest's boot-state file, marks a try-boot to slot,
+// and pushes it back - staging the trial the same way real update tooling
+// would before rebooting into it. The actual rollback on a failed trial is
+// left entirely to the bootloader; nothing here drives it.
+func markTryBoot(inst *instance, bootloader, slot string) error {
+	path, ok := guestStatePath[bootloader]
+	if !ok {
+		return fmt.Errorf("no known boot-state path for bootloader %q", bootloader)
+	}
+
+	return withGuestState(inst, path, func(state *diskimage.BootState) error {
+		return state.MarkTryBoot(slot)
+	})
+}
+
+// currentSlot reads, without modifying, the slot recorded in the guest's
+// boot-state file after the bootloader has already resolved it on its own.
+func currentSlot(inst *instance, bootloader string) (string, error) {
+	path, ok := guestStatePath[bootloader]
+	if !ok {
+		return "", fmt.Errorf("no known boot-state path for bootloader %q", bootloader)
+	}
+
+	var slot string
+	err := readGuestState(inst, path, func(state *diskimage.BootState) error {
+		slot = state.CurrentSlot()
+		return nil
+	})
+	return slot, err
+}
+
+// readGuestState copies the guest's boot-state env file to a local temp
+// file, loads it via diskimage.NewBootState and runs fn against it purely
+// to observe its fields; nothing is written back to the guest.
+func readGuestState(inst *instance, path string, fn func(*diskimage.BootState) error) error {
+	local, err := ioutil.TempFile("", "failover-state")
+	if err != nil {
+		return err
+	}
+	local.Close()
+	defer os.Remove(local.Name())
+
+	if err := inst.scpFrom(path, local.Name()); err != nil {
+		return err
+	}
+
+	state, err := diskimage.NewBootState(local.Name())
+	if err != nil {
+		return err
+	}
+
+	return fn(state)
+}
+
+// withGuestState copies the guest's boot-state env file to a local temp
+// file, loads it via diskimage.NewBootState, runs fn against it, and
+// scp's the result back to the guest.
+func withGuestState(inst *instance, path string, fn func(*diskimage.BootState) error) error {
+	local, err := ioutil.TempFile("", "failover-state")
+	if err != nil {
+		return err
+	}
+	local.Close()
+	defer os.Remove(local.Name())
+
+	if err := inst.scpFrom(path, local.Name()); err != nil {
+		return err
+	}
+
+	state, err := diskimage.NewBootState(local.Name())
+	if err != nil {
+		return err
+	}
+
+	if err := fn(state); err != nil {
+		return err
+	}
+
+	return inst.scpTo(local.Name(), path)
+}
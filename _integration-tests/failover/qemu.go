@@ -0,0 +1,112 @@
+// +build integration
+
+//
+// ubuntu-emu - Tool to download and run Ubuntu Touch emulator instances
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package failover
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"time"
+)
+
+// instance is a running `ubuntu-emu create` guest booted headlessly under
+// qemu, reachable over ssh on sshPort.
+type instance struct {
+	dataDir string
+	sshPort int
+
+	cmd *exec.Cmd
+}
+
+// bootInstance launches the emulator instance found in dataDir under qemu
+// and waits for its ssh port to come up.
+func bootInstance(dataDir string, sshPort int) (*instance, error) {
+	inst := &instance{dataDir: dataDir, sshPort: sshPort}
+
+	if err := inst.powerCycle(2 * time.Minute); err != nil {
+		return nil, err
+	}
+
+	return inst, nil
+}
+
+// qemuArgs is the qemu-system-x86_64 command line used for every boot
+// attempt of inst's disk images.
+func qemuArgs(dataDir string, sshPort int) []string {
+	return []string{
+		"-enable-kvm",
+		"-m", "1024",
+		"-nographic",
+		"-drive", fmt.Sprintf("file=%s/ubuntu-system.img,if=virtio,format=raw", dataDir),
+		"-net", "nic,model=virtio",
+		"-net", fmt.Sprintf("user,hostfwd=tcp::%d-:22", sshPort),
+	}
+}
+
+// powerCycle hard-kills any qemu process already backing inst and starts a
+// fresh one against the same disk images, then waits up to timeout for ssh
+// to come back up.
+//
+// This models an actual power cycle rather than a graceful `reboot`: when
+// the slot being booted into is corrupted, the guest never reaches ssh, so
+// there's no in-guest command to ask for a reboot in the first place. The
+// disk images (and whatever the bootloader wrote to them on the failed
+// attempt - the try-boot stamp, the flipped slot) persist across the kill,
+// exactly like power survives a cycle on real hardware.
+func (inst *instance) powerCycle(timeout time.Duration) error {
+	if inst.cmd != nil && inst.cmd.Process != nil {
+		inst.cmd.Process.Kill()
+		inst.cmd.Wait()
+	}
+
+	cmd := exec.Command("qemu-system-x86_64", qemuArgs(inst.dataDir, inst.sshPort)...)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("cannot start qemu: %s", err)
+	}
+	inst.cmd = cmd
+
+	return inst.waitForSSH(timeout)
+}
+
+// waitForSSH blocks until the guest's ssh port accepts connections or
+// timeout elapses.
+func (inst *instance) waitForSSH(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	addr := fmt.Sprintf("127.0.0.1:%d", inst.sshPort)
+
+	for time.Now().Before(deadline) {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+
+	return fmt.Errorf("timed out waiting for ssh on %s", addr)
+}
+
+// shutdown terminates the qemu process backing inst.
+func (inst *instance) shutdown() error {
+	inst.ssh("poweroff")
+	return inst.cmd.Wait()
+}
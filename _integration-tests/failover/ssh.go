@@ -0,0 +1,70 @@
+// +build integration
+
+//
+// ubuntu-emu - Tool to download and run Ubuntu Touch emulator instances
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package failover
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const sshUser = "phablet"
+
+// ssh runs cmd on the guest over ssh and returns its combined output,
+// trimmed of trailing whitespace.
+func (inst *instance) ssh(cmd string) (string, error) {
+	args := []string{
+		"-p", fmt.Sprintf("%d", inst.sshPort),
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		fmt.Sprintf("%s@127.0.0.1", sshUser),
+		cmd,
+	}
+
+	out, err := exec.Command("ssh", args...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// scpFrom copies remotePath out of the guest to localPath.
+func (inst *instance) scpFrom(remotePath, localPath string) error {
+	return inst.scp(fmt.Sprintf("%s@127.0.0.1:%s", sshUser, remotePath), localPath)
+}
+
+// scpTo copies localPath into the guest at remotePath.
+func (inst *instance) scpTo(localPath, remotePath string) error {
+	return inst.scp(localPath, fmt.Sprintf("%s@127.0.0.1:%s", sshUser, remotePath))
+}
+
+func (inst *instance) scp(src, dst string) error {
+	args := []string{
+		"-P", fmt.Sprintf("%d", inst.sshPort),
+		"-o", "StrictHostKeyChecking=no",
+		"-o", "UserKnownHostsFile=/dev/null",
+		src, dst,
+	}
+
+	out, err := exec.Command("scp", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("scp %s -> %s: %s: %s", src, dst, err, out)
+	}
+	return nil
+}
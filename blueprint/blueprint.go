@@ -0,0 +1,87 @@
+//
+// blueprint - declarative specs for building ubuntu-emu instances
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package blueprint
+
+import (
+	"io/ioutil"
+
+	"github.com/ubports/goget-ubuntu-touch/diskimage"
+
+	"gopkg.in/yaml.v2"
+)
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Blueprint is a declarative description of an ubuntu-emu instance: the
+// image to fetch, its bootloader and partition table label, and the
+// provisioning steps to run once the rootfs is in place. It replaces the
+// many CreateCmd flags with a single file. Arch is still resolved to a
+// device name through ubuntu-emu's devices map, so only the architectures
+// listed there are accepted.
+type Blueprint struct {
+	Arch     string `yaml:"arch"`
+	Channel  string `yaml:"channel"`
+	Server   string `yaml:"server"`
+	Revision int    `yaml:"revision"`
+
+	Bootloader string `yaml:"bootloader"` // "uboot" or "grub"
+
+	// Partitions' Label picks "gpt" or "msdos" for the uboot bootloader.
+	// Its entries are not used when creating the image: each bootloader
+	// lays the disk out with its own fixed table.
+	Partitions diskimage.PartitionTable `yaml:"partitions"`
+	Cmdline    string                   `yaml:"cmdline"`
+
+	// Size is the backing image's total size in GB, large enough to hold
+	// Partitions; 0 falls back to DefaultSize for blueprints describing
+	// the same layout CoreUBootImage has always used.
+	Size int64 `yaml:"size,omitempty"`
+
+	Locale   string `yaml:"locale,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	User     string `yaml:"user,omitempty"`
+
+	// PostInstall is a list of shell commands run inside the rootfs chroot
+	// after provisioning, in order, before the image is unmounted.
+	PostInstall []string `yaml:"post_install,omitempty"`
+}
+
+// DefaultSize is the backing image size, in GB, used when a Blueprint
+// doesn't set Size explicitly.
+const DefaultSize int64 = 4
+
+// Load reads and parses a Blueprint from path, or resolves path as one of
+// the built-in blueprint names (currently "i386" and "armhf") if it names
+// one of those instead of an existing file.
+func Load(path string) (*Blueprint, error) {
+	if bp, ok := builtins[path]; ok {
+		return &bp, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var bp Blueprint
+	if err := yaml.Unmarshal(data, &bp); err != nil {
+		return nil, err
+	}
+
+	return &bp, nil
+}
@@ -0,0 +1,61 @@
+//
+// blueprint - declarative specs for building ubuntu-emu instances
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package blueprint
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadBuiltins(t *testing.T) {
+	for name, want := range map[string]Blueprint{"i386": I386, "armhf": Armhf} {
+		bp, err := Load(name)
+		if err != nil {
+			t.Fatalf("Load(%q): %s", name, err)
+		}
+		if !reflect.DeepEqual(*bp, want) {
+			t.Fatalf("Load(%q) = %+v, want %+v", name, *bp, want)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blueprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "custom.yaml")
+	if err := ioutil.WriteFile(path, []byte("arch: armhf\nbootloader: grub\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	bp, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load(%q): %s", path, err)
+	}
+	if bp.Arch != "armhf" || bp.Bootloader != "grub" {
+		t.Fatalf("Load(%q) = %+v, want Arch=armhf Bootloader=grub", path, *bp)
+	}
+}
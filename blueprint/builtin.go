@@ -0,0 +1,65 @@
+//
+// blueprint - declarative specs for building ubuntu-emu instances
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package blueprint
+
+import "github.com/ubports/goget-ubuntu-touch/diskimage"
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// defaultPartitions is the msdos boot/system-a/system-b/writable layout
+// CoreUBootImage has always used.
+var defaultPartitions = diskimage.PartitionTable{
+	Label: "msdos",
+	Partitions: []diskimage.PartitionEntry{
+		{Label: "system-boot", Dir: "boot", FS: "fat32", Size: 128, Boot: true},
+		{Label: "system-a", Dir: "system-a", FS: "ext4", Size: 1024},
+		{Label: "system-b", Dir: "system-b", FS: "ext4", Size: 1024},
+		{Label: "writable", Dir: "writable", FS: "ext4", Size: -1},
+	},
+}
+
+// I386 is the built-in blueprint equivalent to the previous hardcoded i386
+// emulator defaults.
+var I386 = Blueprint{
+	Arch:       "i386",
+	Channel:    "ubports-touch/16.04/stable",
+	Server:     "https://system-image.ubports.com",
+	Bootloader: "uboot",
+	Partitions: defaultPartitions,
+	Cmdline:    "init=/lib/systemd/systemd ro panic=-1 fixrtc",
+	Size:       DefaultSize,
+}
+
+// Armhf is the built-in blueprint equivalent to the previous hardcoded
+// armhf emulator defaults.
+var Armhf = Blueprint{
+	Arch:       "armhf",
+	Channel:    "ubports-touch/16.04/stable",
+	Server:     "https://system-image.ubports.com",
+	Bootloader: "uboot",
+	Partitions: defaultPartitions,
+	Cmdline:    "init=/lib/systemd/systemd ro panic=-1 fixrtc",
+	Size:       DefaultSize,
+}
+
+// builtins maps the shorthand names Load accepts in place of a file path
+// (e.g. --blueprint=i386) to the blueprint they resolve to.
+var builtins = map[string]Blueprint{
+	"i386":  I386,
+	"armhf": Armhf,
+}
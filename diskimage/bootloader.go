@@ -0,0 +1,46 @@
+//
+// diskimage - handles ubuntu disk images
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package diskimage
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Bootloader abstracts the snappy A/B boot logic away from the image that
+// carries it, so a CoreImage can be paired with whichever boot mechanism the
+// target architecture needs (u-boot on most ARM boards, GRUB on UEFI/x86_64).
+type Bootloader interface {
+	// Partition creates img's backing file and lays out its whole
+	// partition table, including the bootloader's own partitions and any
+	// boot-specific flags.
+	Partition(img *BaseImage) error
+
+	// SetupBoot installs the bootloader assets and default/env files into
+	// the mounted image and wires up the snappy_ab boot logic.
+	SetupBoot(img *BaseImage) error
+
+	// MarkBootSuccessful clears the "trying a new version" state so the
+	// current slot is no longer rolled back on next boot.
+	MarkBootSuccessful(img *BaseImage) error
+
+	// MarkTryBoot preseeds a trial boot of slot, so the bootloader rolls
+	// back to the previous slot if it never confirms itself.
+	MarkTryBoot(img *BaseImage, slot string) error
+
+	// CurrentSlot reports the slot ("a" or "b") the image is currently set
+	// to boot.
+	CurrentSlot(img *BaseImage) (string, error)
+}
@@ -0,0 +1,189 @@
+//
+// diskimage - handles ubuntu disk images
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package diskimage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/ubports/goget-ubuntu-touch/sysutils"
+)
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+const (
+	espLabel = "ESP"
+	espDir   = "system-boot"
+)
+
+const grubCfgTemplate = `# This is a grub configuration file and is entirely generated and managed by
+# Snappy. Modifications may break boot
+set default=0
+set timeout=0
+
+load_env
+
+# if we're trying a new version, check if the stamp file is already there to
+# revert to the other version; otherwise leave a stamp and try it
+if [ "${snappy_mode}" = "try" ]; then
+	if [ -e "/EFI/ubuntu/snappy-stamp.txt" ]; then
+		if [ "${snappy_ab}" = "a" ]; then
+			set snappy_ab=b
+		else
+			set snappy_ab=a
+		fi
+		set snappy_mode=regular
+	else
+		save_env -f (hd0,gpt1)/EFI/ubuntu/snappy-stamp.txt
+	fi
+	save_env snappy_mode snappy_ab
+fi
+
+menuentry "snappy (a)" {
+	set root=(hd0,gpt1)
+	linux /a/{{ .Kernel }} root=/dev/disk/by-label/system-a ro {{ .Cmdline }}
+	initrd /a/{{ .Initrd }}
+}
+
+menuentry "snappy (b)" {
+	set root=(hd0,gpt1)
+	linux /b/{{ .Kernel }} root=/dev/disk/by-label/system-b ro {{ .Cmdline }}
+	initrd /b/{{ .Initrd }}
+}
+
+default="snappy (${snappy_ab})"
+`
+
+const grubenvTemplate = `# GRUB Environment Block
+snappy_mode=regular
+snappy_ab=a
+snappy_trial_boot=0
+`
+
+// bootloaderGrub implements Bootloader for x86_64 UEFI devices: it uses a
+// FAT32 ESP carrying grubx64.efi, grub.cfg and a grubenv instead of the
+// u-boot snappy-system.txt env file.
+type bootloaderGrub struct{}
+
+func (bootloaderGrub) Partition(img *BaseImage) error {
+	table := PartitionTable{
+		Label: "gpt",
+		Partitions: []PartitionEntry{
+			{Label: espLabel, Dir: espDir, FS: "fat32", Size: 128, Boot: true, ESP: true},
+			{Label: string(systemALabel), Dir: string(systemADir), FS: "ext4", Size: 1024},
+			{Label: string(systemBLabel), Dir: string(systemBDir), FS: "ext4", Size: 1024},
+			{Label: string(writableLabel), Dir: string(writableDir), FS: "ext4", Size: -1},
+		},
+	}
+
+	return table.Create(img)
+}
+
+func (bootloaderGrub) SetupBoot(img *BaseImage) error {
+	bootPath := filepath.Join(img.baseMount, string(espDir))
+	grubDir := filepath.Join(bootPath, "EFI", "ubuntu")
+
+	if err := img.GenericBootSetup(bootPath); err != nil {
+		return err
+	}
+
+	for _, part := range img.oem.SystemParts() {
+		bootDtbPath := filepath.Join(bootPath, part, "dtbs")
+		if err := img.provisionDtbs(bootDtbPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(grubDir, 0755); err != nil {
+		return err
+	}
+
+	grubEfiSrc := filepath.Join(img.baseMount, "usr", "lib", "grub", "x86_64-efi", "grubx64.efi")
+	grubEfiDst := filepath.Join(grubDir, "grubx64.efi")
+	if _, err := os.Stat(grubEfiSrc); err == nil {
+		if err := sysutils.CopyFile(grubEfiSrc, grubEfiDst); err != nil {
+			return err
+		}
+	}
+
+	grubCfgFile, err := os.Create(filepath.Join(grubDir, "grub.cfg"))
+	if err != nil {
+		return err
+	}
+	defer grubCfgFile.Close()
+
+	templateData := struct{ Kernel, Initrd, Cmdline string }{
+		Kernel: kernelFileName, Initrd: initrdFileName, Cmdline: img.cmdlineOrDefault(),
+	}
+
+	t := template.Must(template.New("grub.cfg").Parse(grubCfgTemplate))
+	if err := t.Execute(grubCfgFile, templateData); err != nil {
+		return err
+	}
+
+	grubenvFile := filepath.Join(grubDir, "grubenv")
+	if err := ioutil.WriteFile(grubenvFile, []byte(grubenvTemplate), 0644); err != nil {
+		return err
+	}
+
+	// Reload through BootState so the grubenv's snappy_mode/snappy_ab lines
+	// stay the single source of truth for the slot currently installed to.
+	state, err := NewBootState(grubenvFile)
+	if err != nil {
+		return err
+	}
+
+	return state.MarkBootSuccessful()
+}
+
+func grubenvPath(img *BaseImage) string {
+	return filepath.Join(img.baseMount, string(espDir), "EFI", "ubuntu", "grubenv")
+}
+
+// MarkBootSuccessful resets snappy_mode back to "regular" in the grubenv so
+// the current slot is no longer a rollback candidate on the next reboot.
+func (bootloaderGrub) MarkBootSuccessful(img *BaseImage) error {
+	state, err := NewBootState(grubenvPath(img))
+	if err != nil {
+		return err
+	}
+
+	return state.MarkBootSuccessful()
+}
+
+// MarkTryBoot preseeds a trial boot of slot.
+func (bootloaderGrub) MarkTryBoot(img *BaseImage, slot string) error {
+	state, err := NewBootState(grubenvPath(img))
+	if err != nil {
+		return err
+	}
+
+	return state.MarkTryBoot(slot)
+}
+
+// CurrentSlot reports the slot the image is currently set to boot.
+func (bootloaderGrub) CurrentSlot(img *BaseImage) (string, error) {
+	state, err := NewBootState(grubenvPath(img))
+	if err != nil {
+		return "", err
+	}
+
+	return state.CurrentSlot(), nil
+}
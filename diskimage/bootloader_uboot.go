@@ -0,0 +1,122 @@
+//
+// diskimage - handles ubuntu disk images
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package diskimage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// bootloaderUboot implements Bootloader for the u-boot "snappy-system.txt"
+// env-file based A/B boot logic used on most ARM boards.
+type bootloaderUboot struct{}
+
+func (bootloaderUboot) Partition(img *BaseImage) error {
+	label := "msdos"
+	if img.label == "gpt" {
+		label = "gpt"
+	}
+
+	table := PartitionTable{
+		Label: label,
+		Partitions: []PartitionEntry{
+			{Label: string(bootLabel), Dir: string(bootDir), FS: "fat32", Size: 128, Boot: true},
+			{Label: string(systemALabel), Dir: string(systemADir), FS: "ext4", Size: 1024},
+			{Label: string(systemBLabel), Dir: string(systemBDir), FS: "ext4", Size: 1024},
+			{Label: string(writableLabel), Dir: string(writableDir), FS: "ext4", Size: -1},
+		},
+	}
+
+	return table.Create(img)
+}
+
+func (bootloaderUboot) SetupBoot(img *BaseImage) error {
+	bootPath := filepath.Join(img.baseMount, string(bootDir))
+	bootSnappySystemPath := filepath.Join(bootPath, "snappy-system.txt")
+
+	if err := img.GenericBootSetup(bootPath); err != nil {
+		return err
+	}
+
+	// populate both A/B
+	for _, part := range img.oem.SystemParts() {
+		bootDtbPath := filepath.Join(bootPath, part, "dtbs")
+		if err := img.provisionDtbs(bootDtbPath); err != nil {
+			return err
+		}
+	}
+
+	snappySystemFile, err := os.Create(bootSnappySystemPath)
+	if err != nil {
+		return err
+	}
+	defer snappySystemFile.Close()
+
+	var fdtfile string
+	if platform := img.oem.Platform(); platform != "" {
+		fdtfile = fmt.Sprintf("fdtfile=%s.dtb", platform)
+	}
+
+	templateData := struct{ Fdt, Kernel, Initrd, Cmdline string }{
+		Fdt: fdtfile, Kernel: kernelFileName, Initrd: initrdFileName, Cmdline: img.cmdlineOrDefault(),
+	}
+
+	t := template.Must(template.New("snappy-system").Parse(snappySystemTemplate))
+	return t.Execute(snappySystemFile, templateData)
+}
+
+// MarkBootSuccessful resets snappy_mode back to "regular" so the current
+// slot is no longer a rollback candidate on the next reboot.
+func (bootloaderUboot) MarkBootSuccessful(img *BaseImage) error {
+	bootSnappySystemPath := filepath.Join(img.baseMount, string(bootDir), "snappy-system.txt")
+
+	state, err := NewBootState(bootSnappySystemPath)
+	if err != nil {
+		return err
+	}
+
+	return state.MarkBootSuccessful()
+}
+
+// MarkTryBoot preseeds a trial boot of slot.
+func (bootloaderUboot) MarkTryBoot(img *BaseImage, slot string) error {
+	bootSnappySystemPath := filepath.Join(img.baseMount, string(bootDir), "snappy-system.txt")
+
+	state, err := NewBootState(bootSnappySystemPath)
+	if err != nil {
+		return err
+	}
+
+	return state.MarkTryBoot(slot)
+}
+
+// CurrentSlot reports the slot the image is currently set to boot.
+func (bootloaderUboot) CurrentSlot(img *BaseImage) (string, error) {
+	bootSnappySystemPath := filepath.Join(img.baseMount, string(bootDir), "snappy-system.txt")
+
+	state, err := NewBootState(bootSnappySystemPath)
+	if err != nil {
+		return "", err
+	}
+
+	return state.CurrentSlot(), nil
+}
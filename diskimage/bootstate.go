@@ -0,0 +1,196 @@
+//
+// diskimage - handles ubuntu disk images
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package diskimage
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+const (
+	modeRegular = "regular"
+	modeTry     = "try"
+
+	slotA = "a"
+	slotB = "b"
+
+	stampFileName = "snappy-stamp.txt"
+)
+
+// BootState tracks the A/B try-boot state shared by both bootloader
+// backends. It is backed by the same key=value env file the bootloader
+// itself reads at boot (snappy-system.txt for u-boot, grubenv for GRUB)
+// plus a stamp file used to detect a trial boot that never confirmed
+// itself.
+//
+// Only the snappy_mode and snappy_ab lines are rewritten on save; any other
+// content in the env file (boot script variables, comments) is preserved
+// verbatim so BootState can share a file with the bootloader-specific
+// templates in bootloader_uboot.go and bootloader_grub.go.
+type BootState struct {
+	path      string
+	stampPath string
+	lines     []string
+	mode      string
+	slot      string
+}
+
+// NewBootState loads the A/B state from the env file at path, defaulting to
+// slot "a" in "regular" mode if the relevant lines aren't present.
+func NewBootState(path string) (*BootState, error) {
+	state := &BootState{
+		path:      path,
+		stampPath: filepath.Join(filepath.Dir(path), stampFileName),
+		mode:      modeRegular,
+		slot:      slotA,
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		state.lines = append(state.lines, line)
+
+		switch {
+		case strings.HasPrefix(line, "snappy_mode="):
+			state.mode = strings.TrimPrefix(line, "snappy_mode=")
+		case strings.HasPrefix(line, "snappy_ab="):
+			state.slot = strings.TrimPrefix(line, "snappy_ab=")
+		}
+	}
+
+	return state, scanner.Err()
+}
+
+// CurrentSlot returns the slot ("a" or "b") that will be booted next.
+func (s *BootState) CurrentSlot() string {
+	return s.slot
+}
+
+// MarkTryBoot preseeds a trial boot of slot: snappy_mode is set to "try" and
+// any stamp left over from a previous trial is cleared so the next boot
+// attempts slot fresh.
+func (s *BootState) MarkTryBoot(slot string) error {
+	if slot != slotA && slot != slotB {
+		return fmt.Errorf("invalid boot slot %q, must be %q or %q", slot, slotA, slotB)
+	}
+
+	s.slot = slot
+	s.mode = modeTry
+
+	if err := s.clearStamp(); err != nil {
+		return err
+	}
+
+	return s.save()
+}
+
+// MarkBootSuccessful confirms the current slot booted cleanly: snappy_mode
+// goes back to "regular" and the trial stamp is cleared.
+func (s *BootState) MarkBootSuccessful() error {
+	s.mode = modeRegular
+
+	if err := s.clearStamp(); err != nil {
+		return err
+	}
+
+	return s.save()
+}
+
+// Rollback implements the boot-time read-check-flip logic run by the
+// generated boot script: if a trial boot is in progress and the stamp from
+// a previous attempt is already present, the trial never confirmed itself,
+// so flip back to the other slot and resume regular boot. Otherwise this is
+// the first attempt at the new slot, so drop the stamp and let it run.
+func (s *BootState) Rollback() error {
+	if s.mode != modeTry {
+		return nil
+	}
+
+	if _, err := os.Stat(s.stampPath); err == nil {
+		s.slot = otherSlot(s.slot)
+		s.mode = modeRegular
+		return s.save()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	stamp, err := os.Create(s.stampPath)
+	if err != nil {
+		return err
+	}
+
+	return stamp.Close()
+}
+
+func (s *BootState) clearStamp() error {
+	if err := os.Remove(s.stampPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *BootState) save() error {
+	var buf bytes.Buffer
+
+	var wroteMode, wroteSlot bool
+	for _, line := range s.lines {
+		switch {
+		case strings.HasPrefix(line, "snappy_mode="):
+			fmt.Fprintf(&buf, "snappy_mode=%s\n", s.mode)
+			wroteMode = true
+		case strings.HasPrefix(line, "snappy_ab="):
+			fmt.Fprintf(&buf, "snappy_ab=%s\n", s.slot)
+			wroteSlot = true
+		default:
+			fmt.Fprintln(&buf, line)
+		}
+	}
+
+	if !wroteMode {
+		fmt.Fprintf(&buf, "snappy_mode=%s\n", s.mode)
+	}
+	if !wroteSlot {
+		fmt.Fprintf(&buf, "snappy_ab=%s\n", s.slot)
+	}
+
+	return ioutil.WriteFile(s.path, buf.Bytes(), 0644)
+}
+
+func otherSlot(slot string) string {
+	if slot == slotA {
+		return slotB
+	}
+
+	return slotA
+}
@@ -0,0 +1,105 @@
+package diskimage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestBootState(t *testing.T) (*BootState, string) {
+	dir, err := ioutil.TempDir("", "bootstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "snappy-system.txt")
+	if err := ioutil.WriteFile(path, []byte("snappy_mode=regular\nsnappy_ab=a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := NewBootState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return state, dir
+}
+
+// reload re-reads the persisted state, the way a rebooting device would.
+func reload(t *testing.T, path string) *BootState {
+	state, err := NewBootState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return state
+}
+
+func TestBootStateTryBootFailsAndRollsBack(t *testing.T) {
+	state, dir := newTestBootState(t)
+	defer os.RemoveAll(dir)
+
+	if err := state.MarkTryBoot(slotB); err != nil {
+		t.Fatalf("MarkTryBoot: %v", err)
+	}
+
+	// First boot attempt at slot "b": no stamp yet, so Rollback should just
+	// leave a stamp and boot "b".
+	state = reload(t, state.path)
+	if err := state.Rollback(); err != nil {
+		t.Fatalf("Rollback (first attempt): %v", err)
+	}
+	if got := state.CurrentSlot(); got != slotB {
+		t.Fatalf("CurrentSlot() after first attempt = %q, want %q", got, slotB)
+	}
+
+	// Simulate a crash before the image could call MarkBootSuccessful: on
+	// the next boot the stamp from the failed attempt is still there, so
+	// Rollback must flip back to slot "a".
+	state = reload(t, state.path)
+	if err := state.Rollback(); err != nil {
+		t.Fatalf("Rollback (after failure): %v", err)
+	}
+	if got := state.CurrentSlot(); got != slotA {
+		t.Fatalf("CurrentSlot() after failed trial = %q, want %q", got, slotA)
+	}
+
+	state = reload(t, state.path)
+	if state.mode != modeRegular {
+		t.Fatalf("mode after rollback = %q, want %q", state.mode, modeRegular)
+	}
+}
+
+func TestBootStateTryBootSucceeds(t *testing.T) {
+	state, dir := newTestBootState(t)
+	defer os.RemoveAll(dir)
+
+	if err := state.MarkTryBoot(slotB); err != nil {
+		t.Fatalf("MarkTryBoot: %v", err)
+	}
+
+	state = reload(t, state.path)
+	if err := state.Rollback(); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if got := state.CurrentSlot(); got != slotB {
+		t.Fatalf("CurrentSlot() = %q, want %q", got, slotB)
+	}
+
+	// The booted image confirms itself before the next reboot.
+	if err := state.MarkBootSuccessful(); err != nil {
+		t.Fatalf("MarkBootSuccessful: %v", err)
+	}
+
+	state = reload(t, state.path)
+	if got := state.CurrentSlot(); got != slotB {
+		t.Fatalf("CurrentSlot() after success = %q, want %q", got, slotB)
+	}
+	if state.mode != modeRegular {
+		t.Fatalf("mode after success = %q, want %q", state.mode, modeRegular)
+	}
+	if _, err := os.Stat(state.stampPath); !os.IsNotExist(err) {
+		t.Fatalf("stamp file still present after successful boot")
+	}
+}
@@ -0,0 +1,71 @@
+//
+// diskimage - handles ubuntu disk images
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package diskimage
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// CoreGrubImage is the GRUB/UEFI counterpart to CoreUBootImage, used for
+// x86_64 devices that boot via an EFI System Partition instead of u-boot.
+type CoreGrubImage struct {
+	BaseImage
+
+	bootloader Bootloader
+}
+
+func NewCoreGrubImage(location string, size int64, rootSize int, hw HardwareDescription, oem OemDescription, label, cmdline string) *CoreGrubImage {
+	return &CoreGrubImage{
+		BaseImage: BaseImage{
+			hardware:  hw,
+			oem:       oem,
+			location:  location,
+			size:      size,
+			rootSize:  rootSize,
+			partCount: 4,
+			label:     label,
+			cmdline:   cmdline,
+		},
+		bootloader: bootloaderGrub{},
+	}
+}
+
+//Partition creates a partitioned image with an ESP instead of a u-boot boot partition
+func (img *CoreGrubImage) Partition() error {
+	return img.bootloader.Partition(&img.BaseImage)
+}
+
+func (img CoreGrubImage) SetupBoot() error {
+	return img.bootloader.SetupBoot(&img.BaseImage)
+}
+
+// MarkBootSuccessful resets the try-boot state so this image's current slot
+// isn't rolled back on the next reboot.
+func (img CoreGrubImage) MarkBootSuccessful() error {
+	return img.bootloader.MarkBootSuccessful(&img.BaseImage)
+}
+
+// MarkTryBoot preseeds a trial boot of slot, so tooling built on this image
+// (such as ubuntu-emu create) can stage an update before rebooting into it.
+func (img CoreGrubImage) MarkTryBoot(slot string) error {
+	return img.bootloader.MarkTryBoot(&img.BaseImage, slot)
+}
+
+// CurrentSlot reports the slot ("a" or "b") this image is currently set to
+// boot.
+func (img CoreGrubImage) CurrentSlot() (string, error) {
+	return img.bootloader.CurrentSlot(&img.BaseImage)
+}
@@ -12,7 +12,6 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"text/template"
 
 	"github.com/ubports/goget-ubuntu-touch/sysutils"
 )
@@ -31,6 +30,8 @@ import (
 
 type CoreUBootImage struct {
 	BaseImage
+
+	bootloader Bootloader
 }
 
 const snappySystemTemplate = `# This is a snappy variables and boot logic file and is entirely generated and
@@ -48,7 +49,7 @@ initrd_file={{ .Initrd }}
 {{ .Fdt }}
 
 # extra kernel cmdline args, set via mmcroot
-snappy_cmdline=init=/lib/systemd/systemd ro panic=-1 fixrtc
+snappy_cmdline={{ .Cmdline }}
 
 # boot logic
 # either "a" or "b"; target partition we want to boot
@@ -66,9 +67,9 @@ type FlashInstructions struct {
 	Bootloader []string `yaml:"bootloader"`
 }
 
-func NewCoreUBootImage(location string, size int64, rootSize int, hw HardwareDescription, oem OemDescription, label string) *CoreUBootImage {
+func NewCoreUBootImage(location string, size int64, rootSize int, hw HardwareDescription, oem OemDescription, label, cmdline string) *CoreUBootImage {
 	return &CoreUBootImage{
-		BaseImage{
+		BaseImage: BaseImage{
 			hardware:  hw,
 			oem:       oem,
 			location:  location,
@@ -76,76 +77,63 @@ func NewCoreUBootImage(location string, size int64, rootSize int, hw HardwareDes
 			rootSize:  rootSize,
 			partCount: 4,
 			label:     label,
+			cmdline:   cmdline,
 		},
+		bootloader: bootloaderUboot{},
 	}
 }
 
 //Partition creates a partitioned image from an img
 func (img *CoreUBootImage) Partition() error {
-	if err := sysutils.CreateEmptyFile(img.location, img.size, sysutils.GB); err != nil {
-		return err
-	}
-	table := mkLabelMsdos
-
-	if img.label == "gpt" {
-		table = mkLabelGpt
-	}
-	parted, err := newParted(table)
-	if err != nil {
-		return err
-	}
-
-	parted.addPart(bootLabel, bootDir, fsFat32, 128)
-	parted.addPart(systemALabel, systemADir, fsExt4, 1024)
-	parted.addPart(systemBLabel, systemBDir, fsExt4, 1024)
-	parted.addPart(writableLabel, writableDir, fsExt4, -1)
-
-	parted.setBoot(1)
-
-	img.parts = parted.parts
-
-	return parted.create(img.location)
+	return img.bootloader.Partition(&img.BaseImage)
 }
 
 func (img CoreUBootImage) SetupBoot() error {
-	// destinations
-	bootPath := filepath.Join(img.baseMount, string(bootDir))
-	bootSnappySystemPath := filepath.Join(bootPath, "snappy-system.txt")
+	return img.bootloader.SetupBoot(&img.BaseImage)
+}
 
-	if err := img.GenericBootSetup(bootPath); err != nil {
-		return err
-	}
+// MarkBootSuccessful resets the try-boot state so this image's current slot
+// isn't rolled back on the next reboot.
+func (img CoreUBootImage) MarkBootSuccessful() error {
+	return img.bootloader.MarkBootSuccessful(&img.BaseImage)
+}
 
-	// populate both A/B
-	for _, part := range img.oem.SystemParts() {
-		bootDtbPath := filepath.Join(bootPath, part, "dtbs")
-		if err := img.provisionDtbs(bootDtbPath); err != nil {
-			return err
-		}
-	}
+// MarkTryBoot preseeds a trial boot of slot, so tooling built on this image
+// (such as ubuntu-emu create) can stage an update before rebooting into it.
+func (img CoreUBootImage) MarkTryBoot(slot string) error {
+	return img.bootloader.MarkTryBoot(&img.BaseImage, slot)
+}
 
-	snappySystemFile, err := os.Create(bootSnappySystemPath)
-	if err != nil {
-		return err
-	}
-	defer snappySystemFile.Close()
+// CurrentSlot reports the slot ("a" or "b") this image is currently set to
+// boot.
+func (img CoreUBootImage) CurrentSlot() (string, error) {
+	return img.bootloader.CurrentSlot(&img.BaseImage)
+}
 
-	var fdtfile string
-	if platform := img.oem.Platform(); platform != "" {
-		fdtfile = fmt.Sprintf("fdtfile=%s.dtb", platform)
-	}
+// Mountpoint returns the path the image's root filesystem is currently
+// mounted at, for callers (such as ubuntu-emu's post-install scripts) that
+// need to run commands against the real rootfs rather than the data
+// directory it lives under.
+func (img BaseImage) Mountpoint() string {
+	return img.baseMount
+}
 
-	templateData := struct{ Fdt, Kernel, Initrd string }{
-		Fdt: fdtfile, Kernel: kernelFileName, Initrd: initrdFileName,
-	}
+// defaultCmdline is the kernel command line CoreUBootImage/CoreGrubImage
+// have always booted with, used whenever a BaseImage is built without an
+// explicit one.
+const defaultCmdline = "init=/lib/systemd/systemd ro panic=-1 fixrtc"
 
-	t := template.Must(template.New("snappy-system").Parse(snappySystemTemplate))
-	t.Execute(snappySystemFile, templateData)
+// cmdlineOrDefault returns img's kernel command line, falling back to
+// defaultCmdline when none was set.
+func (img BaseImage) cmdlineOrDefault() string {
+	if img.cmdline == "" {
+		return defaultCmdline
+	}
 
-	return nil
+	return img.cmdline
 }
 
-func (img CoreUBootImage) provisionDtbs(bootDtbPath string) error {
+func (img BaseImage) provisionDtbs(bootDtbPath string) error {
 	dtbsPath := filepath.Join(img.baseMount, img.hardware.Dtbs)
 
 	if _, err := os.Stat(dtbsPath); os.IsNotExist(err) {
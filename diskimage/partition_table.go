@@ -0,0 +1,90 @@
+//
+// diskimage - handles ubuntu disk images
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package diskimage
+
+import (
+	"fmt"
+
+	"github.com/ubports/goget-ubuntu-touch/sysutils"
+)
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// PartitionEntry describes a single partition of a PartitionTable: its
+// parted label, the directory name it's mounted under while provisioning,
+// its filesystem, its size in MB (-1 meaning "rest of the disk") and
+// whether it carries the boot flag.
+type PartitionEntry struct {
+	Label string `yaml:"label"`
+	Dir   string `yaml:"dir"`
+	FS    string `yaml:"fs"`
+	Size  int    `yaml:"size"`
+	Boot  bool   `yaml:"boot,omitempty"`
+	ESP   bool   `yaml:"esp,omitempty"`
+}
+
+// PartitionTable is a generic, data-driven description of a disk layout.
+// It replaces the parted calls that used to be hardcoded in each
+// bootloader's Partition method. Each bootloader still builds its own
+// fixed table; a table read from a Blueprint is not passed to them.
+type PartitionTable struct {
+	Label      string           `yaml:"label"` // "gpt" or "msdos"
+	Partitions []PartitionEntry `yaml:"partitions"`
+}
+
+// Create lays out t on img: it creates the backing file, partitions it per
+// the table's entries, and records the resulting parts on img.
+func (t PartitionTable) Create(img *BaseImage) error {
+	if err := sysutils.CreateEmptyFile(img.location, img.size, sysutils.GB); err != nil {
+		return err
+	}
+
+	table := mkLabelMsdos
+	if t.Label == "gpt" {
+		table = mkLabelGpt
+	}
+
+	parted, err := newParted(table)
+	if err != nil {
+		return err
+	}
+
+	for i, entry := range t.Partitions {
+		fs := fsExt4
+		switch entry.FS {
+		case "fat32":
+			fs = fsFat32
+		case "ext4":
+			fs = fsExt4
+		default:
+			return fmt.Errorf("unsupported partition filesystem %q", entry.FS)
+		}
+
+		parted.addPart(entry.Label, entry.Dir, fs, entry.Size)
+		if entry.Boot {
+			parted.setBoot(i + 1)
+		}
+		if entry.ESP {
+			parted.setESP(i + 1)
+		}
+	}
+
+	img.parts = parted.parts
+
+	return parted.create(img.location)
+}
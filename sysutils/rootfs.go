@@ -0,0 +1,98 @@
+//
+// sysutils - collection of utilities for handling system tasks
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package sysutils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+const binSystemdNspawn = "/usr/bin/systemd-nspawn"
+
+// RunInRootfs runs cmd (via "/bin/sh -c") inside rootfs with the given
+// extra environment variables and returns its combined output.
+//
+// When systemd-nspawn is available it's used so foreign-arch rootfs trees
+// work through binfmt_misc without copying a qemu-*-static binary in and
+// out of the tree; otherwise it falls back to a plain chroot, which only
+// works for a rootfs matching the host architecture unless qemuStatic
+// points at a statically-linked qemu-*-static interpreter for the rootfs's
+// architecture, in which case it's copied into rootfs for the duration of
+// the command and removed afterwards. Pass an empty qemuStatic when rootfs
+// already matches the host architecture.
+func RunInRootfs(rootfs, cmd string, env []string, qemuStatic string) ([]byte, error) {
+	if HaveNspawn() {
+		return runInNspawn(rootfs, cmd, env)
+	}
+
+	return runInChroot(rootfs, cmd, env, qemuStatic)
+}
+
+// HaveNspawn reports whether systemd-nspawn is available on the host.
+func HaveNspawn() bool {
+	if _, err := exec.LookPath("systemd-nspawn"); err == nil {
+		return true
+	}
+
+	_, err := exec.LookPath(binSystemdNspawn)
+	return err == nil
+}
+
+func runInNspawn(rootfs, cmd string, env []string) ([]byte, error) {
+	args := []string{"--register=no", "--quiet", "-D", rootfs}
+	for _, e := range env {
+		args = append(args, "--setenv="+e)
+	}
+	args = append(args, "/bin/sh", "-c", cmd)
+
+	return exec.Command("systemd-nspawn", args...).CombinedOutput()
+}
+
+func runInChroot(rootfs, cmd string, env []string, qemuStatic string) ([]byte, error) {
+	if qemuStatic != "" {
+		if err := addQemuStatic(rootfs, qemuStatic); err != nil {
+			return nil, err
+		}
+		defer removeQemuStatic(rootfs, qemuStatic)
+	}
+
+	c := exec.Command("chroot", rootfs, "/bin/sh", "-c", cmd)
+	c.Env = append(os.Environ(), env...)
+
+	return c.CombinedOutput()
+}
+
+// addQemuStatic copies the qemu-*-static interpreter at qemuStatic into
+// rootfs at the same path, so the chroot'd environment can exec foreign-arch
+// binaries without binfmt_misc registered for them.
+func addQemuStatic(rootfs, qemuStatic string) error {
+	dst := filepath.Join(rootfs, qemuStatic)
+	if out, err := exec.Command("cp", qemuStatic, dst).CombinedOutput(); err != nil {
+		return fmt.Errorf("copying %s into %s: %s", qemuStatic, rootfs, out)
+	}
+
+	return nil
+}
+
+func removeQemuStatic(rootfs, qemuStatic string) error {
+	return os.Remove(filepath.Join(rootfs, qemuStatic))
+}
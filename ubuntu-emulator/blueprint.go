@@ -0,0 +1,225 @@
+//
+// ubuntu-emu - Tool to download and run Ubuntu Touch emulator instances
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package main
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/ubports/goget-ubuntu-touch/blueprint"
+	"github.com/ubports/goget-ubuntu-touch/diskimage"
+	"github.com/ubports/goget-ubuntu-touch/sysutils"
+	"github.com/ubports/goget-ubuntu-touch/ubuntuimage"
+)
+
+// bootableImage is satisfied by both CoreUBootImage and CoreGrubImage, so
+// createFromBlueprint can drive either one without caring which the
+// blueprint picked.
+type bootableImage interface {
+	Partition() error
+	SetupBoot() error
+	Provision(files []string) error
+	Mount() error
+	Unmount() error
+
+	// Mountpoint is where the rootfs is currently mounted, for running
+	// post-install scripts against the real tree rather than dataDir.
+	Mountpoint() string
+}
+
+// createFromBlueprint creates instanceName from a declarative blueprint
+// file, replacing the channel/server/arch/bootloader flags with a single
+// spec that also picks the partition table and bootloader constructor.
+func (createCmd *CreateCmd) createFromBlueprint(instanceName string) error {
+	bp, err := blueprint.Load(createCmd.Blueprint)
+	if err != nil {
+		return fmt.Errorf("cannot load blueprint %s: %s", createCmd.Blueprint, err)
+	}
+
+	if syscall.Getuid() != 0 {
+		return errors.New("Creation requires sudo/pkexec (root)")
+	}
+
+	channels, err := ubuntuimage.NewChannels(bp.Server)
+	if err != nil {
+		return err
+	}
+
+	device, ok := devices[bp.Arch]
+	if !ok {
+		return fmt.Errorf("device architecture %q from blueprint not supported", bp.Arch)
+	}
+
+	deviceChannel, err := channels.GetDeviceChannel(bp.Server, bp.Channel, device["name"])
+	if err != nil {
+		return err
+	}
+
+	var image ubuntuimage.Image
+	if bp.Revision <= 0 {
+		image, err = deviceChannel.GetRelativeImage(bp.Revision)
+	} else {
+		image, err = deviceChannel.GetImage(bp.Revision)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Creating \"%s\" from blueprint %s (revision %d)\n", instanceName, createCmd.Blueprint, image.Version)
+	fmt.Println("Downloading...")
+	files, err := createCmd.download(image)
+	if err != nil {
+		return err
+	}
+
+	dataDir := getInstanceDataDir(instanceName)
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return err
+	}
+
+	size := bp.Size
+	if size <= 0 {
+		size = blueprint.DefaultSize
+	}
+
+	// --bootloader, when set to anything other than its default, overrides
+	// the blueprint's own choice.
+	bootloader := bp.Bootloader
+	if createCmd.Bootloader != defaultBootloader {
+		bootloader = createCmd.Bootloader
+	}
+
+	var img bootableImage
+	location := filepath.Join(dataDir, "ubuntu-system.img")
+	switch bootloader {
+	case "grub":
+		img = diskimage.NewCoreGrubImage(location, size, 0, diskimage.HardwareDescription{}, diskimage.OemDescription{}, bp.Partitions.Label, bp.Cmdline)
+	default:
+		img = diskimage.NewCoreUBootImage(location, size, 0, diskimage.HardwareDescription{}, diskimage.OemDescription{}, bp.Partitions.Label, bp.Cmdline)
+	}
+
+	if err := img.Partition(); err != nil {
+		return err
+	}
+
+	if err := sysutils.EscalatePrivs(); err != nil {
+		return err
+	}
+	defer sysutils.DropPrivs()
+
+	if err := img.Mount(); err != nil {
+		return err
+	}
+	defer img.Unmount()
+
+	if err := img.Provision(files); err != nil {
+		return err
+	}
+
+	if err := setBlueprintPassword(bp, img.Mountpoint()); err != nil {
+		return err
+	}
+
+	if err := setBlueprintLocale(bp, img.Mountpoint()); err != nil {
+		return err
+	}
+
+	if err := img.SetupBoot(); err != nil {
+		return err
+	}
+
+	for _, script := range bp.PostInstall {
+		if out, err := sysutils.RunInRootfs(img.Mountpoint(), script, nil, qemuStaticForArch(bp.Arch)); err != nil {
+			return fmt.Errorf("post-install script %q failed: %s", script, out)
+		}
+	}
+
+	fmt.Printf("Succesfully created emulator instance %s in %s\n", instanceName, dataDir)
+	return nil
+}
+
+// defaultBlueprintUser is the account a blueprint's password applies to
+// when it doesn't set User explicitly, matching the classic flow's
+// hardcoded phablet user.
+const defaultBlueprintUser = "phablet"
+
+// setBlueprintPassword sets bp.User's (or phablet's) password in mountpoint
+// to bp.Password, mirroring CreateCmd.setPassword for the blueprint flow.
+// It's a no-op when bp.Password isn't set.
+func setBlueprintPassword(bp *blueprint.Blueprint, mountpoint string) error {
+	if bp.Password == "" {
+		return nil
+	}
+
+	user := bp.User
+	if user == "" {
+		user = defaultBlueprintUser
+	}
+
+	chrootCmd := fmt.Sprintf("echo -n '%s:%s' | chpasswd", user, bp.Password)
+	if out, err := sysutils.RunInRootfs(mountpoint, chrootCmd, nil, qemuStaticForArch(bp.Arch)); err != nil {
+		return errors.New(string(out))
+	}
+
+	return nil
+}
+
+// setBlueprintLocale sets mountpoint's default locale to bp.Locale, after
+// checking it's one `locale -a` actually reports as installed on the
+// image. It's a no-op when bp.Locale isn't set.
+func setBlueprintLocale(bp *blueprint.Blueprint, mountpoint string) error {
+	if bp.Locale == "" {
+		return nil
+	}
+
+	out, err := sysutils.RunInRootfs(mountpoint, "locale -a", nil, qemuStaticForArch(bp.Arch))
+	if err != nil {
+		return err
+	}
+
+	var localeInstalled bool
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if scanner.Text() == bp.Locale {
+			localeInstalled = true
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !localeInstalled {
+		return fmt.Errorf("locale %q is not available on the image", bp.Locale)
+	}
+
+	localeFile, err := os.Create(filepath.Join(mountpoint, "etc", "default", "locale"))
+	if err != nil {
+		return err
+	}
+	defer localeFile.Close()
+
+	_, err = fmt.Fprintf(localeFile, "LANG=%s\nLANGUAGE=%s\nLC_ALL=%s\n", bp.Locale, bp.Locale, bp.Locale)
+	return err
+}
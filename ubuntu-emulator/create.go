@@ -21,10 +21,10 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
 	"syscall"
@@ -36,22 +36,27 @@ import (
 )
 
 type CreateCmd struct {
-	Channel  string `long:"channel" description:"Select device channel"`
-	Server   string `long:"server" description:"Select image server"`
-	Revision int    `long:"revision" description:"Select revision"`
-	RawDisk  bool   `long:"use-raw-disk" description:"Use raw disks instead of qcow2"`
-	SDCard   bool   `long:"with-sdcard" description:"Create an external vfat sdcard"`
-	Arch     string `long:"arch" description:"Device architecture to use (i386 or armhf)"`
-	Password string `long:"password" description:"This sets up the default password for the phablet user" default:"0000"`
-	Locale   string `long:"locale" description:"Use a different locale than the default one (e.g.; --locale es_AR.utf8)"`
+	Channel    string `long:"channel" description:"Select device channel"`
+	Server     string `long:"server" description:"Select image server"`
+	Revision   int    `long:"revision" description:"Select revision"`
+	RawDisk    bool   `long:"use-raw-disk" description:"Use raw disks instead of qcow2"`
+	SDCard     bool   `long:"with-sdcard" description:"Create an external vfat sdcard"`
+	Arch       string `long:"arch" description:"Device architecture to use (i386 or armhf)"`
+	Password   string `long:"password" description:"This sets up the default password for the phablet user" default:"0000"`
+	Locale     string `long:"locale" description:"Use a different locale than the default one (e.g.; --locale es_AR.utf8)"`
+	Bootloader string `long:"bootloader" description:"Bootloader to use for the generated disk image (uboot or grub); only takes effect with --blueprint, where grub overrides the blueprint's bootloader" default:"uboot"`
+	Blueprint  string `long:"blueprint" description:"Create the instance from a declarative blueprint file (or the built-in 'i386'/'armhf' names) instead of the channel/arch flags"`
+	Jobs       int    `long:"jobs" description:"Number of files to download concurrently" default:"3"`
 }
 
 var createCmd CreateCmd
 
 const (
-	defaultChannel = "ubports-touch/16.04/stable"
-	defaultServer  = "https://system-image.ubports.com"
-	defaultArch    = "i386"
+	defaultChannel    = "ubports-touch/16.04/stable"
+	defaultServer     = "https://system-image.ubports.com"
+	defaultArch       = "i386"
+	defaultBootloader = "uboot"
+	defaultJobs       = 3
 )
 
 const (
@@ -93,6 +98,8 @@ func init() {
 	createCmd.Arch = defaultArch
 	createCmd.Channel = defaultChannel
 	createCmd.Server = defaultServer
+	createCmd.Bootloader = defaultBootloader
+	createCmd.Jobs = defaultJobs
 	parser.AddCommand("create",
 		"Create new emulator instance named 'name'",
 		"Creates a new emulator instance name 'name' by downloading the necessary components "+
@@ -106,10 +113,22 @@ func (createCmd *CreateCmd) Execute(args []string) error {
 	}
 	instanceName := args[0]
 
+	if createCmd.Blueprint != "" {
+		return createCmd.createFromBlueprint(instanceName)
+	}
+
 	if err := createCmd.verifyDependencies(); err != nil {
 		return err
 	}
 
+	// The classic flow flashes the android-style boot.img/recovery.img
+	// pair straight out of the channel image, which has no bootloader
+	// abstraction to swap out; --bootloader only picks a CoreUBootImage
+	// vs. CoreGrubImage variant in the --blueprint flow above.
+	if createCmd.Bootloader != defaultBootloader {
+		return fmt.Errorf("--bootloader=%s is only supported with --blueprint; the classic create flow always produces a %s image", createCmd.Bootloader, defaultBootloader)
+	}
+
 	var device string
 	if d, ok := devices[createCmd.Arch]; ok {
 		device = d["name"]
@@ -148,7 +167,10 @@ func (createCmd *CreateCmd) Execute(args []string) error {
 	}
 	fmt.Printf("Creating \"%s\" from %s revision %d\n", instanceName, createCmd.Channel, image.Version)
 	fmt.Println("Downloading...")
-	files, _ := download(image)
+	files, err := createCmd.download(image)
+	if err != nil {
+		return err
+	}
 	dataDir := getInstanceDataDir(instanceName)
 	if os.MkdirAll(dataDir, 0700) != nil {
 		return err
@@ -226,6 +248,12 @@ func extractBuildProperties(systemImage *diskimage.DiskImage, dataDir string) er
 func (createCmd *CreateCmd) verifyDependencies() error {
 	switch createCmd.Arch {
 	case "armhf":
+		// systemd-nspawn only needs binfmt_misc registered for the target
+		// architecture; the qemu-arm-static binary is only required for the
+		// legacy chroot fallback below.
+		if sysutils.HaveNspawn() {
+			break
+		}
 		if _, err := os.Stat(binQemuArmStatic); err != nil {
 			return fmt.Errorf("missing dependency %s (apt install %s)", binQemuArmStatic, pkgQemuUserStatic)
 		}
@@ -301,28 +329,15 @@ func (createCmd *CreateCmd) setLocale(chroot string) error {
 		return nil
 	}
 
-	if createCmd.Arch == "armhf" {
-		if err := addQemuStatic(chroot); err != nil {
-			return err
-		}
-
-		defer removeQemuStatic(chroot)
-	}
-
-	cmd := exec.Command("chroot", chroot, "/bin/sh", "-c", "locale -a")
-	stdout, err := cmd.StdoutPipe()
+	out, err := sysutils.RunInRootfs(chroot, "locale -a", nil, createCmd.qemuStatic())
 	if err != nil {
 		return err
 	}
 
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-
 	// Verify that the locale is actually part of the emulator
 	var localeInstalled bool
 
-	scanner := bufio.NewScanner(stdout)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
 	for scanner.Scan() {
 		locale := scanner.Text()
 		if createCmd.Locale == locale {
@@ -339,10 +354,6 @@ func (createCmd *CreateCmd) setLocale(chroot string) error {
 		return err
 	}
 
-	if err := cmd.Wait(); err != nil {
-		return err
-	}
-
 	// Setup the locale
 	localeFile, err := os.Create(filepath.Join(chroot, "/usr/share/upstart/sessions/emulator-language.conf"))
 	if err != nil {
@@ -357,72 +368,48 @@ func (createCmd *CreateCmd) setLocale(chroot string) error {
 
 // setPassword is an ugly hack to set the password
 func (createCmd *CreateCmd) setPassword(chroot string) error {
-	if createCmd.Arch == "armhf" {
-		if err := addQemuStatic(chroot); err != nil {
-			return err
-		}
-
-		defer removeQemuStatic(chroot)
-	}
-
 	// Run something that would look like this
 	// PATH=$path chroot "$SYSTEM_MOUNTPOINT" /bin/sh -c "echo -n "$user:$password" | chpasswd"
 	chrootCmd := fmt.Sprintf("echo -n '%s:%s' | chpasswd", "phablet", createCmd.Password)
-	if out, err := exec.Command("chroot", chroot, "/bin/sh", "-c", chrootCmd).CombinedOutput(); err != nil {
+	if out, err := sysutils.RunInRootfs(chroot, chrootCmd, nil, createCmd.qemuStatic()); err != nil {
 		return errors.New(string(out))
 	}
 
 	return nil
 }
 
-func addQemuStatic(chroot string) error {
-	dst := filepath.Join(chroot, binQemuArmStatic)
-	if out, err := exec.Command("cp", binQemuArmStatic, dst).CombinedOutput(); err != nil {
-		return fmt.Errorf("issues while setting up password: %s", out)
-	}
-
-	return nil
+// qemuStatic returns the host path to the qemu-*-static interpreter needed
+// to chroot into createCmd.Arch when systemd-nspawn isn't available, or ""
+// when the target architecture matches the host and no interpreter is
+// needed.
+func (createCmd *CreateCmd) qemuStatic() string {
+	return qemuStaticForArch(createCmd.Arch)
 }
 
-func removeQemuStatic(chroot string) error {
-	dst := filepath.Join(chroot, binQemuArmStatic)
+// qemuStaticForArch returns the host path to the qemu-*-static interpreter
+// needed to chroot into arch when systemd-nspawn isn't available, or "" when
+// arch matches the host and no interpreter is needed.
+func qemuStaticForArch(arch string) string {
+	if arch == "armhf" {
+		return binQemuArmStatic
+	}
 
-	return os.Remove(dst)
+	return ""
 }
 
-func download(image ubuntuimage.Image) (files []string, err error) {
+// download fetches every file making up image into the shared cache dir,
+// using a bounded worker pool that resumes interrupted files and verifies
+// their checksums rather than spawning one unsupervised goroutine per file.
+func (createCmd *CreateCmd) download(image ubuntuimage.Image) ([]string, error) {
 	cacheDir := ubuntuimage.GetCacheDir()
-	totalFiles := len(image.Files)
-	done := make(chan string, totalFiles)
-	for _, file := range image.Files {
-		go bitDownloader(file, done, createCmd.Server, cacheDir)
-	}
-	for i := 0; i < totalFiles; i++ {
-		files = append(files, <-done)
-	}
-	return files, nil
-}
 
-// bitDownloader downloads
-func bitDownloader(file ubuntuimage.File, done chan<- string, server, downloadDir string) {
-	err := file.MakeRelativeToServer(server)
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
-	}
-	// hack to circumvent https://code.google.com/p/go/issues/detail?id=1435
-	runtime.GOMAXPROCS(1)
-	runtime.LockOSThread()
-	if err := sysutils.DropPrivs(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	downloader := ubuntuimage.NewDownloader(createCmd.Jobs)
+	downloader.Progress = func(done, total int64) {
+		fmt.Printf("\rDownloading... %d%%", done*100/total)
 	}
 
-	err = file.Download(downloadDir)
-	if err != nil {
-		fmt.Printf("Cannot download %s%s: %s\n", file.Server, file.Path, err)
-		os.Exit(1)
-	}
-	filePath := filepath.Join(downloadDir, file.Path)
-	done <- filePath
+	files, err := downloader.Download(createCmd.Server, cacheDir, image.Files)
+	fmt.Println()
+
+	return files, err
 }
@@ -0,0 +1,140 @@
+//
+// ubuntuimage - manages image files and channels
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package ubuntuimage
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+
+	"github.com/ubports/goget-ubuntu-touch/sysutils"
+)
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+const defaultJobs = 3
+
+// ProgressFunc is called as files download, with the bytes downloaded and
+// the expected total across every file in the batch.
+type ProgressFunc func(downloaded, total int64)
+
+// Downloader fetches the files making up an Image into a cache dir with a
+// bounded worker pool, resuming partial files via HTTP Range requests and
+// verifying each one's sha256 checksum once complete. Unlike a fire-and
+// -forget goroutine-per-file loop, an error downloading one file doesn't
+// abort the others: Download waits for every worker and returns the first
+// error once they've all finished (or nil, with every path filled in).
+type Downloader struct {
+	// Jobs is the number of files downloaded concurrently. Defaults to
+	// defaultJobs if left at zero.
+	Jobs int
+
+	// Progress, if set, is called as bytes arrive across all workers.
+	Progress ProgressFunc
+
+	client *http.Client
+}
+
+// NewDownloader returns a Downloader with jobs concurrent workers, or
+// defaultJobs workers if jobs is less than 1.
+func NewDownloader(jobs int) *Downloader {
+	if jobs < 1 {
+		jobs = defaultJobs
+	}
+
+	return &Downloader{Jobs: jobs, client: http.DefaultClient}
+}
+
+// Download fetches every file in files into cacheDir relative to server,
+// taking a flock on cacheDir so concurrent invocations don't race on the
+// same partial downloads, and returns their local paths in the same order
+// as files.
+func (d *Downloader) Download(server, cacheDir string, files []File) ([]string, error) {
+	unlock, err := sysutils.FlockDir(cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var total int64
+	for _, f := range files {
+		total += f.Size
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		done     int64
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	jobs := d.Jobs
+	if jobs < 1 {
+		jobs = defaultJobs
+	}
+
+	paths := make([]string, len(files))
+	sem := make(chan struct{}, jobs)
+
+	for i := range files {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			f := files[i]
+			if err := f.MakeRelativeToServer(server); err != nil {
+				errOnce.Do(func() { firstErr = err })
+				return
+			}
+
+			path := filepath.Join(cacheDir, f.Path)
+			onProgress := func(n int64) {
+				mu.Lock()
+				done += n
+				progress := done
+				mu.Unlock()
+
+				if d.Progress != nil {
+					d.Progress(progress, total)
+				}
+			}
+
+			if err := downloadResumable(d.client, f.Server+f.Path, path, f.Checksum, onProgress); err != nil {
+				errOnce.Do(func() {
+					firstErr = fmt.Errorf("cannot download %s%s: %s", f.Server, f.Path, err)
+				})
+				return
+			}
+
+			paths[i] = path
+		}(i)
+	}
+
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	return paths, nil
+}
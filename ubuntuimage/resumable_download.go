@@ -0,0 +1,175 @@
+//
+// ubuntuimage - manages image files and channels
+//
+// Copyright (c) 2013 Canonical Ltd.
+//
+// Written by Sergio Schvezov <sergio.schvezov@canonical.com>
+//
+package ubuntuimage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// This program is free software: you can redistribute it and/or modify it
+// under the terms of the GNU General Public License version 3, as published
+// by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful, but
+// WITHOUT ANY WARRANTY; without even the implied warranties of
+// MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+// PURPOSE.  See the GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License along
+// with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// downloadResumable fetches url into dst, appending to whatever partial
+// content is already on disk via an HTTP Range request, and verifies the
+// result against checksum (a lowercase hex sha256, skipped if empty) once
+// complete. onProgress, if not nil, is called with the number of bytes
+// written on every chunk so callers can track overall progress.
+//
+// If dst already exists but doesn't match checksum (or, without one, the
+// remote size) and the server doesn't honour the Range request (status 200
+// instead of 206), the download restarts from scratch rather than
+// appending a second copy onto the end of the partial file.
+func downloadResumable(client *http.Client, url, dst, checksum string, onProgress func(int64)) error {
+	if ok, err := checksumMatches(client, url, dst, checksum); err != nil {
+		return err
+	} else if ok {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if offset > 0 {
+			// the server ignored our Range request; start over
+			if err := out.Truncate(0); err != nil {
+				return err
+			}
+			if _, err := out.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+	case http.StatusPartialContent:
+	case http.StatusRequestedRangeNotSatisfiable:
+		// offset already sits at (or past) the end of the remote file, so
+		// there's nothing left to fetch; what's on disk is final as-is.
+		if ok, err := checksumMatches(client, url, dst, checksum); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("checksum mismatch for %s", dst)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unexpected status downloading %s: %s", url, resp.Status)
+	}
+
+	if _, err := io.Copy(&progressWriter{w: out, onWrite: onProgress}, resp.Body); err != nil {
+		return err
+	}
+
+	if ok, err := checksumMatches(client, url, dst, checksum); err != nil {
+		return err
+	} else if !ok {
+		return fmt.Errorf("checksum mismatch for %s", dst)
+	}
+
+	return nil
+}
+
+// checksumMatches reports whether dst exists and its sha256 matches want.
+// An empty want is treated as "nothing to verify against", but dst must
+// still be checked for completeness against url's reported size - without
+// a checksum, a partial or crashed download would otherwise look the same
+// as a finished one and never resume.
+func checksumMatches(client *http.Client, url, dst, want string) (bool, error) {
+	info, err := os.Stat(dst)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if want == "" {
+		return remoteSizeMatches(client, url, info.Size())
+	}
+
+	f, err := os.Open(dst)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return false, err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)) == want, nil
+}
+
+// remoteSizeMatches reports whether url's Content-Length, per a HEAD
+// request, equals size. An unknown Content-Length is treated as "can't
+// confirm it's complete" rather than assumed to match.
+func remoteSizeMatches(client *http.Client, url string, size int64) (bool, error) {
+	resp, err := client.Head(url)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status checking size of %s: %s", url, resp.Status)
+	}
+
+	return resp.ContentLength >= 0 && resp.ContentLength == size, nil
+}
+
+type progressWriter struct {
+	w       io.Writer
+	onWrite func(int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	if n > 0 && p.onWrite != nil {
+		p.onWrite(int64(n))
+	}
+	return n, err
+}
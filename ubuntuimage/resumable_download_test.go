@@ -0,0 +1,166 @@
+package ubuntuimage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// truncatingHandler announces the full remaining content via Content-Length
+// but only ever writes n bytes of it before closing the connection,
+// simulating a connection dropped mid-body.
+type truncatingHandler struct {
+	content []byte
+	n       int
+}
+
+func (h truncatingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var offset int
+	status := "200 OK"
+	if rng := r.Header.Get("Range"); rng != "" {
+		start := strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-")
+		offset, _ = strconv.Atoi(start)
+		status = "206 Partial Content"
+	}
+
+	remaining := h.content[offset:]
+	limit := h.n
+	if limit > len(remaining) {
+		limit = len(remaining)
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		w.Write(remaining[:limit])
+		return
+	}
+
+	conn, bufrw, err := hijacker.Hijack()
+	if err != nil {
+		w.Write(remaining[:limit])
+		return
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(bufrw, "HTTP/1.1 %s\r\nContent-Length: %d\r\nConnection: close\r\n\r\n", status, len(remaining))
+	bufrw.Write(remaining[:limit])
+	bufrw.Flush()
+}
+
+func TestDownloadResumableResumesAfterDroppedConnection(t *testing.T) {
+	content := []byte("the quick brown fox jumps over the lazy dog, repeated to be long enough to chunk")
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	dir, err := ioutil.TempDir("", "downloadresumable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "file")
+
+	// First attempt: server drops the connection after 10 bytes.
+	dropServer := httptest.NewServer(truncatingHandler{content: content, n: 10})
+	defer dropServer.Close()
+
+	if err := downloadResumable(http.DefaultClient, dropServer.URL, dst, checksum, nil); err == nil {
+		t.Fatal("expected an error from the dropped connection")
+	}
+
+	partial, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partial) != 10 {
+		t.Fatalf("partial download length = %d, want 10", len(partial))
+	}
+
+	// Second attempt against a server that serves the whole thing (honouring
+	// Range) should resume from byte 10 and end up with the full file.
+	fullServer := httptest.NewServer(truncatingHandler{content: content, n: len(content)})
+	defer fullServer.Close()
+
+	if err := downloadResumable(http.DefaultClient, fullServer.URL, dst, checksum, nil); err != nil {
+		t.Fatalf("downloadResumable (resume): %v", err)
+	}
+
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Fatalf("resumed file = %q, want %q", got, content)
+	}
+}
+
+func TestDownloadResumableSkipsVerificationWithoutChecksum(t *testing.T) {
+	content := []byte("no checksum to verify against, just trust the transfer completed")
+
+	dir, err := ioutil.TempDir("", "downloadresumable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "file")
+
+	server := httptest.NewServer(truncatingHandler{content: content, n: len(content)})
+	defer server.Close()
+
+	if err := downloadResumable(http.DefaultClient, server.URL, dst, "", nil); err != nil {
+		t.Fatalf("downloadResumable with empty checksum: %v", err)
+	}
+
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Fatalf("downloaded file = %q, want %q", got, content)
+	}
+
+	// A second call should recognise the file is already complete and not
+	// re-download it, since its size matches the server's Content-Length.
+	if err := downloadResumable(http.DefaultClient, server.URL, dst, "", nil); err != nil {
+		t.Fatalf("downloadResumable re-check with empty checksum: %v", err)
+	}
+}
+
+func TestDownloadResumableResumesPartialFileWithoutChecksum(t *testing.T) {
+	content := []byte("no checksum to verify against, but dst is only partially written")
+
+	dir, err := ioutil.TempDir("", "downloadresumable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "file")
+
+	// Simulate a crashed download: dst exists but only holds the first 10
+	// bytes, and there's no checksum to catch that on its own.
+	if err := ioutil.WriteFile(dst, content[:10], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(truncatingHandler{content: content, n: len(content)})
+	defer server.Close()
+
+	if err := downloadResumable(http.DefaultClient, server.URL, dst, "", nil); err != nil {
+		t.Fatalf("downloadResumable with empty checksum: %v", err)
+	}
+
+	got, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(content) {
+		t.Fatalf("downloaded file = %q, want %q (partial file should have been resumed, not treated as complete)", got, content)
+	}
+}